@@ -0,0 +1,217 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+
+	"gopkg.in/oauth2.v3/errors"
+)
+
+// clientAssertionTypeJWTBearer is the client_assertion_type value defined by
+// RFC 7523 for client_secret_jwt and private_key_jwt.
+const clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// token_endpoint_auth_method values, per RFC 7591 2 and RFC 7523.
+const (
+	ClientAuthMethodBasic         = "client_secret_basic"
+	ClientAuthMethodPost          = "client_secret_post"
+	ClientAuthMethodSecretJWT     = "client_secret_jwt"
+	ClientAuthMethodPrivateKeyJWT = "private_key_jwt"
+	ClientAuthMethodNone          = "none"
+)
+
+// ChainClientInfoHandler tries each ClientInfoHandler in order and returns
+// the first one that resolves a client ID, so NewServer callers can accept
+// client_secret_basic alongside client_secret_post/jwt/private_key_jwt:
+//
+//	srv.ClientInfoHandler = server.ChainClientInfoHandler(
+//		server.ClientBasicHandler,
+//		server.ClientFormHandler,
+//		server.ClientSecretJWTHandler(lookupSecret),
+//	)
+func ChainClientInfoHandler(handlers ...ClientInfoHandler) ClientInfoHandler {
+	return func(r *http.Request) (string, string, error) {
+		var lastErr error = errors.ErrInvalidClient
+		for _, handler := range handlers {
+			clientID, clientSecret, err := handler(r)
+			if err == nil && clientID != "" {
+				return clientID, clientSecret, nil
+			}
+			if err != nil {
+				lastErr = err
+			}
+		}
+		return "", "", lastErr
+	}
+}
+
+// ClientSecretJWTHandler implements the client_secret_jwt client
+// authentication method (RFC 7523): the client asserts its identity with a
+// JWT signed HS256 with its client secret. secretLookup resolves the secret
+// for a claimed client ID.
+func ClientSecretJWTHandler(secretLookup func(clientID string) (string, error)) ClientInfoHandler {
+	return func(r *http.Request) (string, string, error) {
+		assertion, err := clientAssertion(r)
+		if err != nil {
+			return "", "", err
+		}
+
+		clientID, err := clientAssertionSubject(assertion)
+		if err != nil {
+			return "", "", err
+		}
+
+		secret, err := secretLookup(clientID)
+		if err != nil || secret == "" {
+			return "", "", errors.ErrInvalidClient
+		}
+
+		if _, err := jwt.Parse([]byte(assertion), jwt.WithValidate(true), jwt.WithVerify(jwa.HS256, []byte(secret))); err != nil {
+			return "", "", errors.ErrInvalidClient
+		}
+		return clientID, secret, nil
+	}
+}
+
+// PrivateKeyJWTHandler implements the private_key_jwt client authentication
+// method (RFC 7523): the client asserts its identity with a JWT signed by its
+// own RS256/ES256 private key. jwksLookup resolves the public JWKS registered
+// for a claimed client ID.
+func PrivateKeyJWTHandler(jwksLookup func(clientID string) (jwk.Set, error)) ClientInfoHandler {
+	return func(r *http.Request) (string, string, error) {
+		assertion, err := clientAssertion(r)
+		if err != nil {
+			return "", "", err
+		}
+
+		clientID, err := clientAssertionSubject(assertion)
+		if err != nil {
+			return "", "", err
+		}
+
+		keys, err := jwksLookup(clientID)
+		if err != nil || keys == nil {
+			return "", "", errors.ErrInvalidClient
+		}
+
+		if _, err := jwt.Parse([]byte(assertion), jwt.WithValidate(true), jwt.WithKeySet(keys)); err != nil {
+			return "", "", errors.ErrInvalidClient
+		}
+		return clientID, "", nil
+	}
+}
+
+// ClientNoneHandler implements the "none" client authentication method for
+// public clients (RFC 7591 2): it accepts client_id alone and rejects any
+// request that also presents a client_secret, since that combination means
+// the caller is actually a confidential client misconfigured as public.
+func ClientNoneHandler(r *http.Request) (string, string, error) {
+	clientID := r.FormValue("client_id")
+	if clientID == "" {
+		return "", "", errors.ErrInvalidClient
+	}
+	if r.FormValue("client_secret") != "" {
+		return "", "", errors.ErrInvalidClient
+	}
+	return clientID, "", nil
+}
+
+// presentedClientAuthMethod reports which token_endpoint_auth_method r
+// presents, independent of which ClientInfoHandler ultimately accepts it, so
+// it can be checked against a client's registered method.
+func presentedClientAuthMethod(r *http.Request) string {
+	if _, _, ok := r.BasicAuth(); ok {
+		return ClientAuthMethodBasic
+	}
+	if r.FormValue("client_assertion_type") == clientAssertionTypeJWTBearer {
+		if assertion := r.FormValue("client_assertion"); assertion != "" {
+			if clientAssertionAlgorithm(assertion) == jwa.HS256.String() {
+				return ClientAuthMethodSecretJWT
+			}
+			return ClientAuthMethodPrivateKeyJWT
+		}
+	}
+	if r.FormValue("client_secret") != "" {
+		return ClientAuthMethodPost
+	}
+	return ClientAuthMethodNone
+}
+
+// clientAssertionAlgorithm reads the unverified `alg` header of a client
+// assertion JWT, to tell a client_secret_jwt (HS256) apart from a
+// private_key_jwt (RS256/ES256) assertion before it's been verified.
+func clientAssertionAlgorithm(assertion string) string {
+	msg, err := jws.Parse([]byte(assertion))
+	if err != nil || len(msg.Signatures()) == 0 {
+		return ""
+	}
+	return msg.Signatures()[0].ProtectedHeaders().Algorithm().String()
+}
+
+// clientInfo resolves the authenticated client for r: it tries
+// s.ClientAuthMethods in order when set (so a server can accept several
+// token_endpoint_auth_methods side by side), falling back to the single
+// s.ClientInfoHandler otherwise. Once a client ID is resolved, it's checked
+// against the client's registered token_endpoint_auth_method, when
+// s.ClientRegistrationStore knows about it.
+func (s *Server) clientInfo(r *http.Request) (string, string, error) {
+	handler := s.ClientInfoHandler
+	if len(s.ClientAuthMethods) > 0 {
+		handler = ChainClientInfoHandler(s.ClientAuthMethods...)
+	}
+
+	clientID, clientSecret, err := handler(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.checkClientAuthMethod(clientID, presentedClientAuthMethod(r)); err != nil {
+		return "", "", err
+	}
+	return clientID, clientSecret, nil
+}
+
+// checkClientAuthMethod rejects a request authenticated with a
+// token_endpoint_auth_method other than the one clientID registered with
+// (RFC 7591 2), when s.ClientRegistrationStore has a registration for it. A
+// client with no recorded method, or a Server with no registration store at
+// all, is unrestricted.
+func (s *Server) checkClientAuthMethod(clientID, method string) error {
+	if s.ClientRegistrationStore == nil {
+		return nil
+	}
+
+	reg, err := s.ClientRegistrationStore.GetClient(clientID)
+	if err != nil || reg == nil || reg.TokenEndpointAuthMethod == "" {
+		return nil
+	}
+	if reg.TokenEndpointAuthMethod != method {
+		return errors.ErrInvalidClient
+	}
+	return nil
+}
+
+func clientAssertion(r *http.Request) (string, error) {
+	if r.FormValue("client_assertion_type") != clientAssertionTypeJWTBearer {
+		return "", errors.ErrInvalidClient
+	}
+	assertion := r.FormValue("client_assertion")
+	if assertion == "" {
+		return "", errors.ErrInvalidClient
+	}
+	return assertion, nil
+}
+
+// clientAssertionSubject reads the `sub` claim (the client ID) from an
+// unverified assertion, so the caller knows whose key/secret to verify with.
+func clientAssertionSubject(assertion string) (string, error) {
+	token, err := jwt.Parse([]byte(assertion), jwt.WithValidate(false))
+	if err != nil || token.Subject() == "" {
+		return "", errors.ErrInvalidClient
+	}
+	return token.Subject(), nil
+}
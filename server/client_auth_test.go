@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"gopkg.in/oauth2.v3/errors"
+)
+
+func TestClientNoneHandler(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(url.Values{
+		"client_id": {"public-client"},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	clientID, secret, err := ClientNoneHandler(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clientID != "public-client" || secret != "" {
+		t.Errorf("got (%q, %q), want (%q, \"\")", clientID, secret, "public-client")
+	}
+}
+
+func TestClientNoneHandlerRejectsSecret(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(url.Values{
+		"client_id":     {"confidential-client"},
+		"client_secret": {"s3cr3t"},
+	}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if _, _, err := ClientNoneHandler(r); err != errors.ErrInvalidClient {
+		t.Fatalf("got %v, want errors.ErrInvalidClient", err)
+	}
+}
+
+func TestPresentedClientAuthMethod(t *testing.T) {
+	basic := httptest.NewRequest(http.MethodPost, "/token", nil)
+	basic.SetBasicAuth("client", "secret")
+	if got := presentedClientAuthMethod(basic); got != ClientAuthMethodBasic {
+		t.Errorf("got %q, want %q", got, ClientAuthMethodBasic)
+	}
+
+	post := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(url.Values{
+		"client_id":     {"client"},
+		"client_secret": {"secret"},
+	}.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if got := presentedClientAuthMethod(post); got != ClientAuthMethodPost {
+		t.Errorf("got %q, want %q", got, ClientAuthMethodPost)
+	}
+
+	none := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(url.Values{
+		"client_id": {"client"},
+	}.Encode()))
+	none.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if got := presentedClientAuthMethod(none); got != ClientAuthMethodNone {
+		t.Errorf("got %q, want %q", got, ClientAuthMethodNone)
+	}
+}
+
+type fakeClientRegistrationStore struct {
+	clients map[string]*ClientRegistration
+}
+
+func (s *fakeClientRegistrationStore) CreateClient(client *ClientRegistration) error {
+	s.clients[client.ClientID] = client
+	return nil
+}
+
+func (s *fakeClientRegistrationStore) GetClient(clientID string) (*ClientRegistration, error) {
+	return s.clients[clientID], nil
+}
+
+func (s *fakeClientRegistrationStore) UpdateClient(client *ClientRegistration) error {
+	s.clients[client.ClientID] = client
+	return nil
+}
+
+func (s *fakeClientRegistrationStore) DeleteClient(clientID string) error {
+	delete(s.clients, clientID)
+	return nil
+}
+
+func TestCheckClientAuthMethod(t *testing.T) {
+	srv := &Server{ClientRegistrationStore: &fakeClientRegistrationStore{
+		clients: map[string]*ClientRegistration{
+			"confidential": {ClientID: "confidential", TokenEndpointAuthMethod: ClientAuthMethodBasic},
+			"unrestricted": {ClientID: "unrestricted"},
+		},
+	}}
+
+	if err := srv.checkClientAuthMethod("confidential", ClientAuthMethodBasic); err != nil {
+		t.Errorf("expected the registered method to be accepted, got %v", err)
+	}
+	if err := srv.checkClientAuthMethod("confidential", ClientAuthMethodPost); err != errors.ErrInvalidClient {
+		t.Errorf("expected a method other than the registered one to be rejected, got %v", err)
+	}
+	if err := srv.checkClientAuthMethod("unrestricted", ClientAuthMethodNone); err != nil {
+		t.Errorf("expected a client with no recorded method to be unrestricted, got %v", err)
+	}
+	if err := srv.checkClientAuthMethod("unknown-client", ClientAuthMethodBasic); err != nil {
+		t.Errorf("expected an unregistered client to be unrestricted, got %v", err)
+	}
+}
+
+func TestCheckClientAuthMethodNoStore(t *testing.T) {
+	srv := &Server{}
+	if err := srv.checkClientAuthMethod("any-client", ClientAuthMethodBasic); err != nil {
+		t.Errorf("expected no registration store to mean unrestricted, got %v", err)
+	}
+}
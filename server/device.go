@@ -0,0 +1,232 @@
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/errors"
+)
+
+// DeviceCodeGrant is the grant_type value for RFC 8628 device authorization,
+// accepted by ValidationTokenRequest and GetAccessToken.
+const DeviceCodeGrant oauth2.GrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// defaultUserCodeAlphabet is the RFC 8628 Appendix A recommended alphabet:
+// upper-case, no vowels and no easily confused characters (0/O, 1/I).
+const defaultUserCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+const defaultUserCodeLength = 8
+
+// defaultDeviceCodePollInterval is the minimum time a client is required to
+// wait between polls of the same device_code when Server.DeviceCodePollInterval
+// is unset, per RFC 8628 3.5's recommended default.
+const defaultDeviceCodePollInterval = 5 * time.Second
+
+// deviceCodeSlowDownIncrement is how much the effective poll interval for a
+// device_code grows each time the client polls too fast, per RFC 8628 3.5
+// ("the client MUST increase its polling interval by 5 seconds for this and
+// all subsequent requests").
+const deviceCodeSlowDownIncrement = 5 * time.Second
+
+// DeviceAuthorization is the device_code/user_code pair issued by
+// HandleDeviceAuthorizationRequest.
+type DeviceAuthorization struct {
+	DeviceCode              string
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	ExpiresIn               time.Duration
+	Interval                int
+}
+
+// DeviceCodeManager is implemented by a Manager that supports the device
+// flow. A Manager that doesn't implement it causes
+// HandleDeviceAuthorizationRequest and the device_code grant to fail with
+// ErrUnsupportedGrantType.
+type DeviceCodeManager interface {
+	// GenerateDeviceCode allocates a new device_code/user_code pair for
+	// clientID. userCodeAlphabet/userCodeLength configure the user_code
+	// charset and length (see Server.DeviceUserCodeAlphabet/DeviceUserCodeLength).
+	GenerateDeviceCode(clientID, scope, verificationURI, userCodeAlphabet string, userCodeLength int) (*DeviceAuthorization, error)
+	// AuthorizeUserCode binds userID to the device authorization matching
+	// userCode once the end user has completed verification. It returns
+	// ErrExpiredDeviceCode if userCode is unknown or has expired.
+	AuthorizeUserCode(userCode, userID string) error
+	// PollDeviceCode resolves a polling token request for deviceCode. It
+	// returns ErrAuthorizationPending, ErrSlowDown, ErrExpiredDeviceCode or
+	// ErrDeviceAccessDenied while the user hasn't completed (or rejected)
+	// verification, and the issued TokenInfo once they have.
+	PollDeviceCode(deviceCode string) (oauth2.TokenInfo, error)
+}
+
+// deviceCodeRateLimiter enforces a minimum interval between polls of the
+// same device_code, independent of whatever the Manager itself tracks. A
+// device_code (or client_id) that keeps polling too fast has its effective
+// interval bumped by deviceCodeSlowDownIncrement on every such poll, per RFC
+// 8628 3.5. Limiting is applied per device_code, so one client can't starve
+// another's polling, and per client_id, so a client can't evade the limit by
+// requesting a fresh device_code for every poll.
+type deviceCodeRateLimiter struct {
+	mu              sync.Mutex
+	lastPoll        map[string]time.Time
+	intervals       map[string]time.Duration
+	clientLastPoll  map[string]time.Time
+	clientIntervals map[string]time.Duration
+}
+
+func (rl *deviceCodeRateLimiter) allow(deviceCode, clientID string, interval time.Duration) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.lastPoll == nil {
+		rl.lastPoll = make(map[string]time.Time)
+		rl.intervals = make(map[string]time.Duration)
+		rl.clientLastPoll = make(map[string]time.Time)
+		rl.clientIntervals = make(map[string]time.Duration)
+	}
+
+	deviceAllowed := rl.check(rl.lastPoll, rl.intervals, deviceCode, interval)
+	clientAllowed := true
+	if clientID != "" {
+		clientAllowed = rl.check(rl.clientLastPoll, rl.clientIntervals, clientID, interval)
+	}
+	return deviceAllowed && clientAllowed
+}
+
+func (rl *deviceCodeRateLimiter) check(lastPoll map[string]time.Time, intervals map[string]time.Duration, key string, interval time.Duration) bool {
+	effective := interval
+	if cur, ok := intervals[key]; ok {
+		effective = cur
+	}
+
+	now := time.Now()
+	last, polled := lastPoll[key]
+	lastPoll[key] = now
+	if polled && now.Sub(last) < effective {
+		intervals[key] = effective + deviceCodeSlowDownIncrement
+		return false
+	}
+	intervals[key] = effective
+	return true
+}
+
+// evict removes deviceCode's rate-limiting state once it's known to no
+// longer be pollable (PollDeviceCode returned ErrExpiredDeviceCode), so a
+// steady stream of expired device codes doesn't grow lastPoll/intervals
+// without bound. The per-client_id state is left alone, since the client
+// itself remains subject to limiting across whatever device_code it
+// requests next.
+func (rl *deviceCodeRateLimiter) evict(deviceCode string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	delete(rl.lastPoll, deviceCode)
+	delete(rl.intervals, deviceCode)
+}
+
+// DeviceFlowError is a device flow polling status as defined by RFC 8628
+// section 3.5, surfaced through GetErrorData as the token endpoint's `error`.
+type DeviceFlowError struct{ Code string }
+
+func (e *DeviceFlowError) Error() string { return e.Code }
+
+// Polling statuses a DeviceCodeManager's PollDeviceCode may return.
+var (
+	ErrAuthorizationPending = &DeviceFlowError{Code: "authorization_pending"}
+	ErrSlowDown             = &DeviceFlowError{Code: "slow_down"}
+	ErrExpiredDeviceCode    = &DeviceFlowError{Code: "expired_token"}
+	ErrDeviceAccessDenied   = &DeviceFlowError{Code: "access_denied"}
+)
+
+// HandleDeviceAuthorizationRequest implements the RFC 8628 device
+// authorization endpoint: it authenticates the client and allocates a
+// device_code/user_code pair.
+func (s *Server) HandleDeviceAuthorizationRequest(w http.ResponseWriter, r *http.Request) error {
+	dm, ok := s.Manager.(DeviceCodeManager)
+	if !ok {
+		return s.tokenError(w, errors.ErrUnsupportedGrantType)
+	}
+
+	clientID, _, err := s.clientInfo(r)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	if fn := s.ClientAuthorizedHandler; fn != nil {
+		allowed, err := fn(clientID, DeviceCodeGrant)
+		if err != nil {
+			return s.tokenError(w, err)
+		} else if !allowed {
+			return s.tokenError(w, errors.ErrUnauthorizedClient)
+		}
+	}
+
+	alphabet := s.DeviceUserCodeAlphabet
+	if alphabet == "" {
+		alphabet = defaultUserCodeAlphabet
+	}
+	length := s.DeviceUserCodeLength
+	if length == 0 {
+		length = defaultUserCodeLength
+	}
+
+	da, err := dm.GenerateDeviceCode(clientID, r.FormValue("scope"), requestBaseURL(r)+"/device", alphabet, length)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	data := map[string]interface{}{
+		"device_code":      da.DeviceCode,
+		"user_code":        da.UserCode,
+		"verification_uri": da.VerificationURI,
+		"expires_in":       int64(da.ExpiresIn / time.Second),
+	}
+	if da.VerificationURIComplete != "" {
+		data["verification_uri_complete"] = da.VerificationURIComplete
+	}
+	if da.Interval > 0 {
+		data["interval"] = da.Interval
+	}
+	return s.token(w, data, nil)
+}
+
+// HandleUserCodeVerificationRequest implements the RFC 8628 end-user
+// verification step: it authenticates the user via s.UserAuthorizationHandler
+// (the same hook HandleAuthorizeRequest uses) and, once authenticated, binds
+// their UserID to the device authorization identified by the submitted
+// user_code, so that the matching device_code's poll can succeed.
+func (s *Server) HandleUserCodeVerificationRequest(w http.ResponseWriter, r *http.Request) error {
+	dm, ok := s.Manager.(DeviceCodeManager)
+	if !ok {
+		return s.tokenError(w, errors.ErrUnsupportedGrantType)
+	}
+
+	userCode := r.FormValue("user_code")
+	if userCode == "" {
+		return s.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	userID, err := s.UserAuthorizationHandler(w, r)
+	if err != nil {
+		return err
+	} else if userID == "" {
+		// UserAuthorizationHandler has already written its own response
+		// (e.g. a login redirect), same convention as HandleAuthorizeRequest.
+		return nil
+	}
+
+	if err := dm.AuthorizeUserCode(userCode, userID); err != nil {
+		return s.tokenError(w, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deviceCodeLimiter lazily initializes s's per-device-code poll rate limiter.
+func (s *Server) deviceCodeLimiter() *deviceCodeRateLimiter {
+	s.deviceLimiterInit.Do(func() {
+		s.deviceLimiterInstance = &deviceCodeRateLimiter{}
+	})
+	return s.deviceLimiterInstance
+}
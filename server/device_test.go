@@ -0,0 +1,94 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceCodeRateLimiterAllow(t *testing.T) {
+	rl := &deviceCodeRateLimiter{}
+
+	if !rl.allow("device-1", "", time.Hour) {
+		t.Fatal("expected the first poll to be allowed")
+	}
+	if rl.allow("device-1", "", time.Hour) {
+		t.Fatal("expected a second immediate poll of the same device_code to be rate-limited")
+	}
+	if !rl.allow("device-2", "", time.Hour) {
+		t.Fatal("expected a poll of a different device_code to be unaffected")
+	}
+}
+
+func TestDeviceCodeRateLimiterAllowsAfterInterval(t *testing.T) {
+	rl := &deviceCodeRateLimiter{}
+
+	if !rl.allow("device-1", "", time.Millisecond) {
+		t.Fatal("expected the first poll to be allowed")
+	}
+	time.Sleep(5 * time.Millisecond)
+	if !rl.allow("device-1", "", time.Millisecond) {
+		t.Fatal("expected a poll after the interval has elapsed to be allowed")
+	}
+}
+
+func TestDeviceCodeRateLimiterBumpsIntervalOnSlowDown(t *testing.T) {
+	rl := &deviceCodeRateLimiter{}
+
+	if !rl.allow("device-1", "", 10*time.Millisecond) {
+		t.Fatal("expected the first poll to be allowed")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if rl.allow("device-1", "", 10*time.Millisecond) {
+		t.Fatal("expected a too-fast poll to be rate-limited")
+	}
+	// the effective interval should now be 10ms+5s (deviceCodeSlowDownIncrement),
+	// so a poll after only the original 10ms has elapsed is still rejected.
+	time.Sleep(12 * time.Millisecond)
+	if rl.allow("device-1", "", 10*time.Millisecond) {
+		t.Fatal("expected the bumped interval to still be in effect")
+	}
+}
+
+func TestDeviceCodeRateLimiterPerClient(t *testing.T) {
+	rl := &deviceCodeRateLimiter{}
+
+	if !rl.allow("device-1", "client-1", time.Hour) {
+		t.Fatal("expected the first poll to be allowed")
+	}
+	// a fresh device_code for the same client must not evade the per-client limit.
+	if rl.allow("device-2", "client-1", time.Hour) {
+		t.Fatal("expected a second immediate poll from the same client_id, via a different device_code, to be rate-limited")
+	}
+	if !rl.allow("device-3", "client-2", time.Hour) {
+		t.Fatal("expected a poll from a different client_id to be unaffected")
+	}
+}
+
+func TestDeviceCodeRateLimiterEvict(t *testing.T) {
+	rl := &deviceCodeRateLimiter{}
+
+	if !rl.allow("device-1", "", time.Hour) {
+		t.Fatal("expected the first poll to be allowed")
+	}
+	rl.evict("device-1")
+	if !rl.allow("device-1", "", time.Hour) {
+		t.Fatal("expected an evicted device_code to be treated as never polled")
+	}
+	if len(rl.lastPoll) != 1 || len(rl.intervals) != 1 {
+		t.Errorf("lastPoll/intervals left stale evicted state behind: %v / %v", rl.lastPoll, rl.intervals)
+	}
+}
+
+func TestDeviceFlowErrorMessages(t *testing.T) {
+	cases := map[*DeviceFlowError]string{
+		ErrAuthorizationPending: "authorization_pending",
+		ErrSlowDown:             "slow_down",
+		ErrExpiredDeviceCode:    "expired_token",
+		ErrDeviceAccessDenied:   "access_denied",
+	}
+	for err, want := range cases {
+		if got := err.Error(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/errors"
+)
+
+// tokenRevoker is implemented by a Manager that can remove stored tokens.
+// HandleRevocationRequest degrades to a no-op success response (as RFC 7009
+// 2.2 allows for already-invalid tokens) when the Manager doesn't implement
+// it.
+type tokenRevoker interface {
+	RemoveAccessToken(access string) error
+	RemoveRefreshToken(refresh string) error
+}
+
+// HandleIntrospectionRequest implements RFC 7662 token introspection.
+func (s *Server) HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return s.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	clientID, _, err := s.clientInfo(r)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		return s.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	return s.token(w, s.introspectToken(token, r.FormValue("token_type_hint"), clientID), nil)
+}
+
+// introspectToken looks up token and renders its RFC 7662 introspection
+// response, scoped to the authenticated caller: a token issued to a
+// different client is reported as inactive rather than leaking its
+// metadata to an unrelated caller.
+func (s *Server) introspectToken(token, hint, clientID string) map[string]interface{} {
+	inactive := map[string]interface{}{"active": false}
+
+	loaders := []func(string) (oauth2.TokenInfo, error){s.Manager.LoadAccessToken, s.Manager.LoadRefreshToken}
+	if hint == "refresh_token" {
+		loaders[0], loaders[1] = loaders[1], loaders[0]
+	}
+
+	for _, load := range loaders {
+		ti, err := load(token)
+		if err != nil || ti == nil {
+			continue
+		}
+
+		if ti.GetClientID() != clientID {
+			return inactive
+		}
+
+		tokenType, createAt, expiresIn := "access_token", ti.GetAccessCreateAt(), ti.GetAccessExpiresIn()
+		if ti.GetAccess() != token {
+			tokenType, createAt, expiresIn = "refresh_token", ti.GetRefreshCreateAt(), ti.GetRefreshExpiresIn()
+		}
+
+		if expiresIn > 0 && createAt.Add(expiresIn).Before(time.Now()) {
+			return inactive
+		}
+
+		data := map[string]interface{}{
+			"active":     true,
+			"scope":      ti.GetScope(),
+			"client_id":  ti.GetClientID(),
+			"username":   ti.GetUserID(),
+			"sub":        ti.GetUserID(),
+			"aud":        ti.GetClientID(),
+			"token_type": tokenType,
+			"iat":        createAt.Unix(),
+		}
+		if expiresIn > 0 {
+			data["exp"] = createAt.Add(expiresIn).Unix()
+		}
+		if s.Issuer != "" {
+			data["iss"] = s.Issuer
+		}
+		return data
+	}
+
+	return inactive
+}
+
+// HandleRevocationRequest implements RFC 7009 token revocation.
+func (s *Server) HandleRevocationRequest(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return s.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	clientID, _, err := s.clientInfo(r)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		return s.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	hint := r.FormValue("token_type_hint")
+	loaders := []func(string) (oauth2.TokenInfo, error){s.Manager.LoadAccessToken, s.Manager.LoadRefreshToken}
+	if hint == "refresh_token" {
+		loaders[0], loaders[1] = loaders[1], loaders[0]
+	}
+
+	var ti oauth2.TokenInfo
+	for _, load := range loaders {
+		if t, err := load(token); err == nil && t != nil {
+			ti = t
+			break
+		}
+	}
+
+	// Per RFC 7009 2.2, an already-invalid or unknown token is not an error.
+	if ti == nil {
+		return s.token(w, map[string]interface{}{}, nil)
+	}
+
+	// Reject cross-client revocation: a client may only revoke its own tokens.
+	if ti.GetClientID() != clientID {
+		return s.tokenError(w, errors.ErrInvalidClient)
+	}
+
+	if tr, ok := s.Manager.(tokenRevoker); ok {
+		if ti.GetAccess() == token {
+			tr.RemoveAccessToken(token)
+		} else {
+			tr.RemoveRefreshToken(token)
+		}
+	}
+
+	return s.token(w, map[string]interface{}{}, nil)
+}
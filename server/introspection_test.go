@@ -0,0 +1,187 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/oauth2.v3"
+)
+
+// fakeTokenInfo is a minimal oauth2.TokenInfo for introspection/revocation tests.
+type fakeTokenInfo struct {
+	clientID, userID, scope        string
+	access, refresh                string
+	accessCreateAt, refreshCreateAt time.Time
+	accessExpiresIn, refreshExpiresIn time.Duration
+	nonce                           string
+	authTime                        time.Time
+}
+
+func (t *fakeTokenInfo) New() oauth2.TokenInfo                        { return &fakeTokenInfo{} }
+func (t *fakeTokenInfo) GetClientID() string                          { return t.clientID }
+func (t *fakeTokenInfo) SetClientID(v string)                         { t.clientID = v }
+func (t *fakeTokenInfo) GetUserID() string                            { return t.userID }
+func (t *fakeTokenInfo) SetUserID(v string)                           { t.userID = v }
+func (t *fakeTokenInfo) GetRedirectURI() string                       { return "" }
+func (t *fakeTokenInfo) SetRedirectURI(string)                        {}
+func (t *fakeTokenInfo) GetScope() string                             { return t.scope }
+func (t *fakeTokenInfo) SetScope(v string)                            { t.scope = v }
+func (t *fakeTokenInfo) GetCode() string                              { return "" }
+func (t *fakeTokenInfo) SetCode(string)                               {}
+func (t *fakeTokenInfo) GetCodeCreateAt() time.Time                   { return time.Time{} }
+func (t *fakeTokenInfo) SetCodeCreateAt(time.Time)                    {}
+func (t *fakeTokenInfo) GetCodeExpiresIn() time.Duration              { return 0 }
+func (t *fakeTokenInfo) SetCodeExpiresIn(time.Duration)               {}
+func (t *fakeTokenInfo) GetAccess() string                            { return t.access }
+func (t *fakeTokenInfo) SetAccess(v string)                           { t.access = v }
+func (t *fakeTokenInfo) GetAccessCreateAt() time.Time                 { return t.accessCreateAt }
+func (t *fakeTokenInfo) SetAccessCreateAt(v time.Time)                { t.accessCreateAt = v }
+func (t *fakeTokenInfo) GetAccessExpiresIn() time.Duration            { return t.accessExpiresIn }
+func (t *fakeTokenInfo) SetAccessExpiresIn(v time.Duration)           { t.accessExpiresIn = v }
+func (t *fakeTokenInfo) GetRefresh() string                           { return t.refresh }
+func (t *fakeTokenInfo) SetRefresh(v string)                          { t.refresh = v }
+func (t *fakeTokenInfo) GetRefreshCreateAt() time.Time                { return t.refreshCreateAt }
+func (t *fakeTokenInfo) SetRefreshCreateAt(v time.Time)               { t.refreshCreateAt = v }
+func (t *fakeTokenInfo) GetRefreshExpiresIn() time.Duration           { return t.refreshExpiresIn }
+func (t *fakeTokenInfo) SetRefreshExpiresIn(v time.Duration)          { t.refreshExpiresIn = v }
+func (t *fakeTokenInfo) GetNonce() string                             { return t.nonce }
+func (t *fakeTokenInfo) SetNonce(v string)                            { t.nonce = v }
+func (t *fakeTokenInfo) GetAuthTime() time.Time                       { return t.authTime }
+func (t *fakeTokenInfo) SetAuthTime(v time.Time)                      { t.authTime = v }
+
+// introspectionManager is a fake oauth2.Manager exercising only
+// HandleIntrospectionRequest/HandleRevocationRequest's code paths; the rest
+// of the interface is stubbed out.
+type introspectionManager struct {
+	byAccess                       map[string]*fakeTokenInfo
+	byRefresh                      map[string]*fakeTokenInfo
+	removedAccess, removedRefresh []string
+}
+
+func (m *introspectionManager) GetClient(clientID string) (oauth2.ClientInfo, error) {
+	return nil, errNotFound
+}
+
+func (m *introspectionManager) GenerateAuthToken(rt oauth2.ResponseType, tgr *oauth2.TokenGenerateRequest) (oauth2.TokenInfo, error) {
+	return nil, errNotFound
+}
+
+func (m *introspectionManager) GenerateAccessToken(rt oauth2.GrantType, tgr *oauth2.TokenGenerateRequest) (oauth2.TokenInfo, error) {
+	return nil, errNotFound
+}
+
+func (m *introspectionManager) RefreshAccessToken(tgr *oauth2.TokenGenerateRequest) (oauth2.TokenInfo, error) {
+	return nil, errNotFound
+}
+
+func (m *introspectionManager) LoadAccessToken(access string) (oauth2.TokenInfo, error) {
+	if ti, ok := m.byAccess[access]; ok {
+		return ti, nil
+	}
+	return nil, errNotFound
+}
+
+func (m *introspectionManager) LoadRefreshToken(refresh string) (oauth2.TokenInfo, error) {
+	if ti, ok := m.byRefresh[refresh]; ok {
+		return ti, nil
+	}
+	return nil, errNotFound
+}
+
+func (m *introspectionManager) RemoveAccessToken(access string) error {
+	m.removedAccess = append(m.removedAccess, access)
+	return nil
+}
+
+func (m *introspectionManager) RemoveRefreshToken(refresh string) error {
+	m.removedRefresh = append(m.removedRefresh, refresh)
+	return nil
+}
+
+var errNotFound = &notFoundError{}
+
+type notFoundError struct{}
+
+func (*notFoundError) Error() string { return "not found" }
+
+func newIntrospectionServer(m *introspectionManager) *Server {
+	s := &Server{Manager: m}
+	s.ClientInfoHandler = func(r *http.Request) (string, string, error) {
+		return r.FormValue("caller_client_id"), "", nil
+	}
+	return s
+}
+
+func introspectRequest(clientID string, form url.Values) *http.Request {
+	form.Set("caller_client_id", clientID)
+	r := httptest.NewRequest("POST", "/introspect", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestIntrospectTokenRejectsCrossClient(t *testing.T) {
+	m := &introspectionManager{byAccess: map[string]*fakeTokenInfo{
+		"tok": {clientID: "owner", scope: "read", accessExpiresIn: time.Hour, accessCreateAt: time.Now(), access: "tok"},
+	}}
+	s := newIntrospectionServer(m)
+
+	data := s.introspectToken("tok", "", "owner")
+	if data["active"] != true {
+		t.Fatalf("expected active=true for owning client, got %#v", data)
+	}
+	if data["aud"] != "owner" {
+		t.Fatalf("expected aud=owner, got %#v", data["aud"])
+	}
+
+	data = s.introspectToken("tok", "", "someone-else")
+	if data["active"] != false {
+		t.Fatalf("expected active=false for a non-owning client, got %#v", data)
+	}
+}
+
+func TestIntrospectTokenExpired(t *testing.T) {
+	m := &introspectionManager{byAccess: map[string]*fakeTokenInfo{
+		"tok": {clientID: "owner", access: "tok", accessExpiresIn: time.Second, accessCreateAt: time.Now().Add(-time.Hour)},
+	}}
+	s := newIntrospectionServer(m)
+
+	if data := s.introspectToken("tok", "", "owner"); data["active"] != false {
+		t.Fatalf("expected active=false for an expired token, got %#v", data)
+	}
+}
+
+func TestHandleRevocationRequestRejectsCrossClient(t *testing.T) {
+	m := &introspectionManager{byAccess: map[string]*fakeTokenInfo{
+		"tok": {clientID: "owner", access: "tok"},
+	}}
+	s := newIntrospectionServer(m)
+
+	w := httptest.NewRecorder()
+	err := s.HandleRevocationRequest(w, introspectRequest("attacker", url.Values{"token": {"tok"}}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.removedAccess) != 0 {
+		t.Fatalf("expected the token to survive a cross-client revoke attempt, got removed=%v", m.removedAccess)
+	}
+}
+
+func TestHandleRevocationRequestRemovesOwnToken(t *testing.T) {
+	m := &introspectionManager{byAccess: map[string]*fakeTokenInfo{
+		"tok": {clientID: "owner", access: "tok"},
+	}}
+	s := newIntrospectionServer(m)
+
+	w := httptest.NewRecorder()
+	err := s.HandleRevocationRequest(w, introspectRequest("owner", url.Values{"token": {"tok"}}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(m.removedAccess) != 1 || m.removedAccess[0] != "tok" {
+		t.Fatalf("expected the owner to be able to revoke its own token, got removed=%v", m.removedAccess)
+	}
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/errors"
+)
+
+// IDTokenData carries the claims used to build an OpenID Connect ID Token.
+type IDTokenData struct {
+	Issuer    string
+	Subject   string
+	Audience  string
+	Nonce     string
+	AuthTime  time.Time
+	ExpiresIn time.Duration
+}
+
+// IDTokenGenerator issues a signed ID Token for the given claims.
+type IDTokenGenerator interface {
+	Token(data *IDTokenData) (string, error)
+}
+
+// UserInfoHandler supplies the claims HandleUserInfoRequest returns for ti's
+// subject, beyond the mandatory `sub`. Leave nil to return just `sub`.
+type UserInfoHandler func(ti oauth2.TokenInfo) (map[string]interface{}, error)
+
+// JWKSIDTokenGenerator is the default IDTokenGenerator. It signs RS256 ID
+// Tokens with a single managed signing key and can expose that key as a JWKS
+// for HandleJWKSRequest.
+type JWKSIDTokenGenerator struct {
+	key   *rsa.PrivateKey
+	keyID string
+}
+
+// NewJWKSIDTokenGenerator creates an IDTokenGenerator backed by key, published
+// under keyID in the JWKS document.
+func NewJWKSIDTokenGenerator(key *rsa.PrivateKey, keyID string) *JWKSIDTokenGenerator {
+	return &JWKSIDTokenGenerator{key: key, keyID: keyID}
+}
+
+// Token implements IDTokenGenerator.
+func (g *JWKSIDTokenGenerator) Token(data *IDTokenData) (string, error) {
+	now := time.Now()
+
+	token := jwt.New()
+	token.Set(jwt.IssuerKey, data.Issuer)
+	token.Set(jwt.SubjectKey, data.Subject)
+	token.Set(jwt.AudienceKey, data.Audience)
+	token.Set(jwt.IssuedAtKey, now.Unix())
+	token.Set(jwt.ExpirationKey, now.Add(data.ExpiresIn).Unix())
+	token.Set("auth_time", data.AuthTime.Unix())
+	if data.Nonce != "" {
+		token.Set("nonce", data.Nonce)
+	}
+
+	hdrs := jws.NewHeaders()
+	if err := hdrs.Set(jws.KeyIDKey, g.keyID); err != nil {
+		return "", err
+	}
+
+	signed, err := jwt.Sign(token, jwa.RS256, g.key, jwt.WithHeaders(hdrs))
+	if err != nil {
+		return "", err
+	}
+	return string(signed), nil
+}
+
+// JWKS returns the public JSON Web Key Set for the generator's signing key,
+// suitable for serving from HandleJWKSRequest.
+func (g *JWKSIDTokenGenerator) JWKS() (jwk.Set, error) {
+	key, err := jwk.New(&g.key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.KeyIDKey, g.keyID); err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.AlgorithmKey, jwa.RS256.String()); err != nil {
+		return nil, err
+	}
+
+	set := jwk.NewSet()
+	set.Add(key)
+	return set, nil
+}
+
+// jwksProvider is implemented by IDTokenGenerators that can publish a JWKS.
+type jwksProvider interface {
+	JWKS() (jwk.Set, error)
+}
+
+// HandleDiscoveryRequest serves an OpenID Connect discovery document
+// (https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata)
+// describing this server's endpoints and capabilities.
+func (s *Server) HandleDiscoveryRequest(w http.ResponseWriter, r *http.Request) error {
+	base := requestBaseURL(r)
+	issuer := s.Issuer
+	if issuer == "" {
+		issuer = base
+	}
+
+	responseTypes := []string{}
+	grantTypes := []string{}
+	if s.Config != nil {
+		for _, rt := range s.Config.AllowedResponseTypes {
+			responseTypes = append(responseTypes, string(rt))
+			if rt == oauth2.Token {
+				// the implicit flow is driven entirely by the authorize
+				// endpoint's response_type=token; it has no token-endpoint
+				// grant_type of its own, so it can't come from AllowedGrantTypes.
+				grantTypes = append(grantTypes, "implicit")
+			}
+		}
+		for _, gt := range s.Config.AllowedGrantTypes {
+			grantTypes = append(grantTypes, string(gt))
+		}
+	}
+
+	doc := map[string]interface{}{
+		"issuer":                                issuer,
+		"authorization_endpoint":                base + "/authorize",
+		"token_endpoint":                        base + "/token",
+		"userinfo_endpoint":                     base + "/userinfo",
+		"jwks_uri":                              base + "/.well-known/jwks.json",
+		"introspection_endpoint":                base + "/introspect",
+		"revocation_endpoint":                   base + "/revoke",
+		"response_types_supported":              responseTypes,
+		"grant_types_supported":                 grantTypes,
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid"},
+		"token_endpoint_auth_methods_supported": []string{
+			ClientAuthMethodBasic,
+			ClientAuthMethodPost,
+			ClientAuthMethodSecretJWT,
+			ClientAuthMethodPrivateKeyJWT,
+			ClientAuthMethodNone,
+		},
+	}
+
+	if _, ok := s.Manager.(DeviceCodeManager); ok {
+		doc["device_authorization_endpoint"] = base + "/device_authorization"
+	}
+	if s.ClientRegistrationStore != nil {
+		doc["registration_endpoint"] = base + "/register"
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// HandleJWKSRequest serves the JSON Web Key Set for s.IDTokenGenerator, when
+// it implements jwksProvider (as JWKSIDTokenGenerator does).
+func (s *Server) HandleJWKSRequest(w http.ResponseWriter, r *http.Request) error {
+	provider, ok := s.IDTokenGenerator.(jwksProvider)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+
+	set, err := provider.JWKS()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(set)
+}
+
+// HandleUserInfoRequest implements the OpenID Connect UserInfo endpoint
+// (https://openid.net/specs/openid-connect-core-1_0.html#UserInfo): it
+// resolves the bearer access token and returns the claims for the subject it
+// was issued to -- always `sub`, plus whatever s.UserInfoHandler supplies.
+func (s *Server) HandleUserInfoRequest(w http.ResponseWriter, r *http.Request) error {
+	ti, err := s.ValidationBearerToken(r)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	if !scopeContains(ti.GetScope(), "openid") {
+		return s.tokenError(w, errors.ErrInvalidScope)
+	}
+
+	data := map[string]interface{}{
+		"sub": ti.GetUserID(),
+	}
+
+	if fn := s.UserInfoHandler; fn != nil {
+		claims, err := fn(ti)
+		if err != nil {
+			return s.tokenError(w, err)
+		}
+		for k, v := range claims {
+			if k == "sub" {
+				continue
+			}
+			data[k] = v
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	return json.NewEncoder(w).Encode(data)
+}
+
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func scopeContains(scope, target string) bool {
+	for _, sc := range strings.Fields(scope) {
+		if sc == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/oauth2.v3"
+)
+
+func TestHandleDiscoveryRequest(t *testing.T) {
+	srv := &Server{Issuer: "https://issuer.example.com"}
+
+	r := httptest.NewRequest(http.MethodGet, "https://issuer.example.com/.well-known/openid-configuration", nil)
+	w := httptest.NewRecorder()
+
+	if err := srv.HandleDiscoveryRequest(w, r); err != nil {
+		t.Fatalf("HandleDiscoveryRequest returned an error: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("response body is not valid JSON: %v", err)
+	}
+
+	for _, field := range []string{"userinfo_endpoint", "grant_types_supported", "jwks_uri", "token_endpoint"} {
+		if _, ok := doc[field]; !ok {
+			t.Errorf("discovery document is missing %q", field)
+		}
+	}
+	if doc["issuer"] != srv.Issuer {
+		t.Errorf("issuer = %v, want %v", doc["issuer"], srv.Issuer)
+	}
+
+	methods, ok := doc["token_endpoint_auth_methods_supported"].([]interface{})
+	if !ok {
+		t.Fatalf("token_endpoint_auth_methods_supported = %v, want a list", doc["token_endpoint_auth_methods_supported"])
+	}
+	for _, want := range []string{ClientAuthMethodBasic, ClientAuthMethodPost, ClientAuthMethodSecretJWT, ClientAuthMethodPrivateKeyJWT, ClientAuthMethodNone} {
+		found := false
+		for _, m := range methods {
+			if m == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("token_endpoint_auth_methods_supported is missing %q", want)
+		}
+	}
+}
+
+func TestHandleDiscoveryRequestDerivesSupportedTypesFromConfig(t *testing.T) {
+	srv := &Server{
+		Config: &Config{
+			AllowedGrantTypes:    []oauth2.GrantType{oauth2.AuthorizationCode, oauth2.Refreshing},
+			AllowedResponseTypes: []oauth2.ResponseType{oauth2.Code, oauth2.Token},
+		},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://issuer.example.com/.well-known/openid-configuration", nil)
+	w := httptest.NewRecorder()
+	if err := srv.HandleDiscoveryRequest(w, r); err != nil {
+		t.Fatalf("HandleDiscoveryRequest returned an error: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("response body is not valid JSON: %v", err)
+	}
+
+	responseTypes := toStringSlice(doc["response_types_supported"])
+	if !containsAll(responseTypes, "code", "token") {
+		t.Errorf("response_types_supported = %v, want code and token", responseTypes)
+	}
+
+	grantTypes := toStringSlice(doc["grant_types_supported"])
+	if !containsAll(grantTypes, "authorization_code", "refresh_token", "implicit") {
+		t.Errorf("grant_types_supported = %v, want authorization_code, refresh_token and implicit (since token is an allowed response_type)", grantTypes)
+	}
+	if contains(grantTypes, string(DeviceCodeGrant)) {
+		t.Errorf("grant_types_supported = %v, should not advertise the device_code grant when it's not in AllowedGrantTypes", grantTypes)
+	}
+
+	if _, ok := doc["device_authorization_endpoint"]; ok {
+		t.Error("did not expect device_authorization_endpoint when the Manager doesn't implement DeviceCodeManager")
+	}
+	if _, ok := doc["registration_endpoint"]; ok {
+		t.Error("did not expect registration_endpoint when ClientRegistrationStore is nil")
+	}
+}
+
+// fakeDeviceCodeManager is a Manager that also implements DeviceCodeManager,
+// to exercise HandleDiscoveryRequest's conditional device_authorization_endpoint.
+type fakeDeviceCodeManager struct{ introspectionManager }
+
+func (m *fakeDeviceCodeManager) GenerateDeviceCode(clientID, scope, verificationURI, userCodeAlphabet string, userCodeLength int) (*DeviceAuthorization, error) {
+	return nil, errNotFound
+}
+func (m *fakeDeviceCodeManager) AuthorizeUserCode(userCode, userID string) error { return errNotFound }
+func (m *fakeDeviceCodeManager) PollDeviceCode(deviceCode string) (oauth2.TokenInfo, error) {
+	return nil, errNotFound
+}
+
+func TestHandleDiscoveryRequestAdvertisesDeviceAndRegistrationEndpoints(t *testing.T) {
+	srv := &Server{
+		Config:                  &Config{AllowedGrantTypes: []oauth2.GrantType{DeviceCodeGrant}},
+		Manager:                 &fakeDeviceCodeManager{},
+		ClientRegistrationStore: &fakeClientRegistrationStore{clients: map[string]*ClientRegistration{}},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "https://issuer.example.com/.well-known/openid-configuration", nil)
+	w := httptest.NewRecorder()
+	if err := srv.HandleDiscoveryRequest(w, r); err != nil {
+		t.Fatalf("HandleDiscoveryRequest returned an error: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("response body is not valid JSON: %v", err)
+	}
+
+	if _, ok := doc["device_authorization_endpoint"]; !ok {
+		t.Error("expected device_authorization_endpoint when the Manager implements DeviceCodeManager")
+	}
+	if _, ok := doc["registration_endpoint"]; !ok {
+		t.Error("expected registration_endpoint when ClientRegistrationStore is configured")
+	}
+}
+
+func toStringSlice(v interface{}) []string {
+	raw, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(raw))
+	for _, e := range raw {
+		if s, ok := e.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func contains(list []string, target string) bool {
+	for _, v := range list {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(list []string, targets ...string) bool {
+	for _, target := range targets {
+		if !contains(list, target) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHandleUserInfoRequest(t *testing.T) {
+	manager := &introspectionManager{byAccess: map[string]*fakeTokenInfo{
+		"access-token": {userID: "user-1", scope: "openid profile"},
+	}}
+	srv := &Server{Manager: manager}
+	srv.UserInfoHandler = func(ti oauth2.TokenInfo) (map[string]interface{}, error) {
+		return map[string]interface{}{"name": "Jane Doe", "sub": "ignored"}, nil
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/userinfo", nil)
+	r.Header.Set("Authorization", "Bearer access-token")
+	w := httptest.NewRecorder()
+
+	if err := srv.HandleUserInfoRequest(w, r); err != nil {
+		t.Fatalf("HandleUserInfoRequest returned an error: %v", err)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &data); err != nil {
+		t.Fatalf("response body is not valid JSON: %v", err)
+	}
+	if data["sub"] != "user-1" {
+		t.Errorf("sub = %v, want %q (UserInfoHandler must not override sub)", data["sub"], "user-1")
+	}
+	if data["name"] != "Jane Doe" {
+		t.Errorf("name = %v, want %q", data["name"], "Jane Doe")
+	}
+}
+
+func TestScopeContains(t *testing.T) {
+	if !scopeContains("openid profile", "openid") {
+		t.Error("expected scopeContains to find openid in a multi-value scope")
+	}
+	if scopeContains("profile", "openid") {
+		t.Error("expected scopeContains to not find openid when it's absent")
+	}
+	if scopeContains("", "openid") {
+		t.Error("expected scopeContains to return false for an empty scope")
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func s256Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestCheckCodeVerifier(t *testing.T) {
+	valid := strings.Repeat("a", 43)
+	tooShort := strings.Repeat("a", 42)
+	tooLong := strings.Repeat("a", 129)
+
+	cases := []struct {
+		name string
+		v    string
+		want bool
+	}{
+		{"minimum length", valid, true},
+		{"maximum length", strings.Repeat("a", 128), true},
+		{"too short", tooShort, false},
+		{"too long", tooLong, false},
+		{"invalid character", strings.Repeat("a", 42) + "!", false},
+		{"unreserved characters", strings.Repeat("a", 39) + "-._~", true},
+	}
+	for _, c := range cases {
+		if got := CheckCodeVerifier(c.v); got != c.want {
+			t.Errorf("%s: CheckCodeVerifier(%q) = %v, want %v", c.name, c.v, got, c.want)
+		}
+	}
+}
+
+func TestVerifyCodeChallengeS256(t *testing.T) {
+	verifier := strings.Repeat("a", 43)
+	challenge := s256Challenge(verifier)
+
+	if !VerifyCodeChallenge(verifier, challenge, CodeChallengeS256) {
+		t.Fatal("expected the matching S256 verifier to be accepted")
+	}
+	if VerifyCodeChallenge(verifier+"x", challenge, CodeChallengeS256) {
+		t.Fatal("expected a mismatched S256 verifier to be rejected")
+	}
+}
+
+func TestVerifyCodeChallengePlain(t *testing.T) {
+	verifier := strings.Repeat("a", 43)
+
+	if !VerifyCodeChallenge(verifier, verifier, CodeChallengePlain) {
+		t.Fatal("expected a matching plain verifier to be accepted")
+	}
+	if VerifyCodeChallenge(verifier, verifier+"x", CodeChallengePlain) {
+		t.Fatal("expected a mismatched plain verifier to be rejected")
+	}
+}
+
+func TestVerifyCodeChallengeNoChallenge(t *testing.T) {
+	if !VerifyCodeChallenge("", "", "") {
+		t.Fatal("expected no challenge on the authorize request to require no verifier")
+	}
+}
+
+func TestVerifyCodeChallengeMissingVerifier(t *testing.T) {
+	challenge := s256Challenge(strings.Repeat("a", 43))
+	if VerifyCodeChallenge("", challenge, CodeChallengeS256) {
+		t.Fatal("expected a missing code_verifier to be rejected when a challenge was captured")
+	}
+}
+
+func TestVerifyCodeChallengeRejectsShortVerifier(t *testing.T) {
+	shortVerifier := strings.Repeat("a", 10)
+	challenge := s256Challenge(shortVerifier)
+	if VerifyCodeChallenge(shortVerifier, challenge, CodeChallengeS256) {
+		t.Fatal("expected an out-of-bounds verifier to be rejected even if it matches the challenge")
+	}
+}
+
+func TestVerifyCodeChallengeDowngradedMethod(t *testing.T) {
+	// A client that captured an S256 challenge must not be satisfiable by
+	// presenting the raw verifier and pretending the method was "plain".
+	verifier := strings.Repeat("a", 43)
+	challenge := s256Challenge(verifier)
+
+	if VerifyCodeChallenge(verifier, challenge, CodeChallengePlain) {
+		t.Fatal("expected a plain comparison against an S256 challenge to fail")
+	}
+}
+
+func TestCheckCodeChallengeMethod(t *testing.T) {
+	for method, want := range map[string]bool{
+		"":       true,
+		"plain":  true,
+		"S256":   true,
+		"sha256": false,
+		"none":   false,
+	} {
+		if got := CheckCodeChallengeMethod(method); got != want {
+			t.Errorf("CheckCodeChallengeMethod(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
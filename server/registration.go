@@ -0,0 +1,322 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/errors"
+)
+
+// ClientRegistration is a dynamically registered client, per RFC 7591/7592.
+type ClientRegistration struct {
+	ClientID                string          `json:"client_id"`
+	ClientSecret            string          `json:"client_secret,omitempty"`
+	ClientName              string          `json:"client_name,omitempty"`
+	RedirectURIs            []string        `json:"redirect_uris"`
+	GrantTypes              []string        `json:"grant_types,omitempty"`
+	ResponseTypes           []string        `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method,omitempty"`
+	Scope                   string          `json:"scope,omitempty"`
+	JWKSURI                 string          `json:"jwks_uri,omitempty"`
+	JWKS                    json.RawMessage `json:"jwks,omitempty"`
+	Contacts                []string        `json:"contacts,omitempty"`
+	PolicyURI               string          `json:"policy_uri,omitempty"`
+	// SoftwareStatement is a signed JWT (RFC 7591 2.3) asserting some or all
+	// of this metadata on behalf of the client software's vendor. When set,
+	// it's verified by Server.SoftwareStatementHandler and its claims take
+	// precedence over the same-named fields submitted alongside it. It's
+	// never persisted: HandleClientRegistrationRequest and
+	// HandleClientUpdateRequest clear it before handing the registration to
+	// the ClientRegistrationStore.
+	SoftwareStatement       string `json:"software_statement,omitempty"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri,omitempty"`
+}
+
+// ClientRegistrationStore persists dynamically registered clients for
+// HandleClientRegistrationRequest and its RFC 7592 management counterparts.
+type ClientRegistrationStore interface {
+	CreateClient(client *ClientRegistration) error
+	GetClient(clientID string) (*ClientRegistration, error)
+	UpdateClient(client *ClientRegistration) error
+	DeleteClient(clientID string) error
+}
+
+// ClientRegistrar is implemented by a Manager that allocates the client_id
+// and client_secret for a dynamically registered client (RFC 7591 3.2.1),
+// e.g. to keep them consistent with however it issues client identities
+// elsewhere. HandleClientRegistrationRequest uses it when s.Manager
+// implements it, and falls back to generating random values itself
+// otherwise.
+type ClientRegistrar interface {
+	RegisterClient(client *ClientRegistration) (clientID, clientSecret string, err error)
+}
+
+// InitialAccessTokenHandler authorizes a dynamic client registration request
+// by its presented initial access token (RFC 7591 3), before any metadata is
+// looked at. Leave nil to allow open registration.
+type InitialAccessTokenHandler func(token string) (bool, error)
+
+// SoftwareStatementHandler verifies a software_statement JWT (RFC 7591 2.3)
+// and returns the client metadata it asserts. HandleClientRegistrationRequest
+// merges those claims over the same-named fields in the request body, so a
+// verified statement can't be overridden by an untrusted submission.
+type SoftwareStatementHandler func(statement string) (*ClientRegistration, error)
+
+// HandleClientRegistrationRequest implements RFC 7591 dynamic client
+// registration: it validates the submitted metadata against the server's
+// allowed grant/response types, allocates client_id/client_secret and a
+// registration_access_token, persists the result and returns it.
+func (s *Server) HandleClientRegistrationRequest(w http.ResponseWriter, r *http.Request) error {
+	if s.ClientRegistrationStore == nil {
+		return s.tokenError(w, errors.ErrServerError)
+	}
+	if r.Method != "POST" {
+		return s.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	if fn := s.InitialAccessTokenHandler; fn != nil {
+		token, ok := s.BearerAuth(r)
+		if !ok {
+			return s.tokenError(w, errors.ErrUnauthorizedClient)
+		}
+		allowed, err := fn(token)
+		if err != nil {
+			return s.tokenError(w, err)
+		} else if !allowed {
+			return s.tokenError(w, errors.ErrUnauthorizedClient)
+		}
+	}
+
+	var client ClientRegistration
+	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
+		return s.tokenError(w, errors.ErrInvalidRequest)
+	}
+
+	if client.SoftwareStatement != "" {
+		if s.SoftwareStatementHandler == nil {
+			return s.tokenError(w, errors.ErrInvalidRequest)
+		}
+		asserted, err := s.SoftwareStatementHandler(client.SoftwareStatement)
+		if err != nil {
+			return s.tokenError(w, errors.ErrInvalidClient)
+		}
+		mergeAssertedClientMetadata(&client, asserted)
+		client.SoftwareStatement = ""
+	}
+
+	if err := s.validateClientMetadata(&client); err != nil {
+		return s.tokenError(w, err)
+	}
+
+	clientID, clientSecret, err := s.registerClient(&client)
+	if err != nil {
+		return err
+	}
+	registrationAccessToken, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	client.ClientID = clientID
+	client.ClientSecret = clientSecret
+	client.RegistrationAccessToken = registrationAccessToken
+	client.RegistrationClientURI = requestBaseURL(r) + "/register/" + clientID
+
+	if err := s.ClientRegistrationStore.CreateClient(&client); err != nil {
+		return err
+	}
+
+	return s.writeClientRegistration(w, &client, http.StatusCreated)
+}
+
+// HandleClientReadRequest implements the RFC 7592 client read operation.
+func (s *Server) HandleClientReadRequest(w http.ResponseWriter, r *http.Request, clientID string) error {
+	client, err := s.authenticateRegistrationRequest(r, clientID)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+	return s.writeClientRegistration(w, client, http.StatusOK)
+}
+
+// HandleClientUpdateRequest implements the RFC 7592 client update operation.
+func (s *Server) HandleClientUpdateRequest(w http.ResponseWriter, r *http.Request, clientID string) error {
+	client, err := s.authenticateRegistrationRequest(r, clientID)
+	if err != nil {
+		return s.tokenError(w, err)
+	}
+
+	var update ClientRegistration
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		return s.tokenError(w, errors.ErrInvalidRequest)
+	}
+	if update.SoftwareStatement != "" {
+		if s.SoftwareStatementHandler == nil {
+			return s.tokenError(w, errors.ErrInvalidRequest)
+		}
+		asserted, err := s.SoftwareStatementHandler(update.SoftwareStatement)
+		if err != nil {
+			return s.tokenError(w, errors.ErrInvalidClient)
+		}
+		mergeAssertedClientMetadata(&update, asserted)
+		update.SoftwareStatement = ""
+	}
+	if err := s.validateClientMetadata(&update); err != nil {
+		return s.tokenError(w, err)
+	}
+
+	update.ClientID = client.ClientID
+	update.ClientSecret = client.ClientSecret
+	update.RegistrationAccessToken = client.RegistrationAccessToken
+	update.RegistrationClientURI = client.RegistrationClientURI
+
+	if err := s.ClientRegistrationStore.UpdateClient(&update); err != nil {
+		return err
+	}
+	return s.writeClientRegistration(w, &update, http.StatusOK)
+}
+
+// HandleClientDeleteRequest implements the RFC 7592 client delete operation.
+func (s *Server) HandleClientDeleteRequest(w http.ResponseWriter, r *http.Request, clientID string) error {
+	if _, err := s.authenticateRegistrationRequest(r, clientID); err != nil {
+		return s.tokenError(w, err)
+	}
+
+	if err := s.ClientRegistrationStore.DeleteClient(clientID); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) authenticateRegistrationRequest(r *http.Request, clientID string) (*ClientRegistration, error) {
+	client, err := s.ClientRegistrationStore.GetClient(clientID)
+	if err != nil || client == nil {
+		return nil, errors.ErrInvalidClient
+	}
+
+	token, ok := s.BearerAuth(r)
+	if !ok || token != client.RegistrationAccessToken {
+		return nil, errors.ErrInvalidClient
+	}
+	return client, nil
+}
+
+// registerClient allocates client_id/client_secret for client, deferring to
+// s.Manager's ClientRegistrar when it implements one.
+func (s *Server) registerClient(client *ClientRegistration) (clientID, clientSecret string, err error) {
+	if cr, ok := s.Manager.(ClientRegistrar); ok {
+		return cr.RegisterClient(client)
+	}
+	if clientID, err = randomToken(16); err != nil {
+		return "", "", err
+	}
+	if clientSecret, err = randomToken(32); err != nil {
+		return "", "", err
+	}
+	return clientID, clientSecret, nil
+}
+
+// requiresRedirectURIs reports whether client's grant/response types need a
+// redirect_uri to deliver an authorization code or access token to, per RFC
+// 7591 3.1. Grant-less, non-redirect clients (client_credentials, the device
+// grant, ...) don't, and defaulting this to "always required" would wrongly
+// reject them. An empty GrantTypes defaults to authorization_code per RFC
+// 7591 2, which does require one.
+func requiresRedirectURIs(client *ClientRegistration) bool {
+	grantTypes := client.GrantTypes
+	if len(grantTypes) == 0 {
+		grantTypes = []string{string(oauth2.AuthorizationCode)}
+	}
+	for _, gt := range grantTypes {
+		switch oauth2.GrantType(strings.TrimSpace(gt)) {
+		case oauth2.AuthorizationCode, oauth2.Implicit:
+			return true
+		}
+	}
+	for _, rt := range client.ResponseTypes {
+		switch oauth2.ResponseType(strings.TrimSpace(rt)) {
+		case oauth2.Code, oauth2.Token:
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) validateClientMetadata(client *ClientRegistration) error {
+	if len(client.RedirectURIs) == 0 && requiresRedirectURIs(client) {
+		return errors.ErrInvalidRequest
+	}
+	// RFC 7591 2: a client registers its keys as an inline document or by
+	// reference, never both.
+	if len(client.JWKS) > 0 && client.JWKSURI != "" {
+		return errors.ErrInvalidRequest
+	}
+
+	for _, gt := range client.GrantTypes {
+		if !s.CheckGrantType(oauth2.GrantType(strings.TrimSpace(gt))) {
+			return errors.ErrInvalidRequest
+		}
+	}
+	for _, rt := range client.ResponseTypes {
+		if !s.CheckResponseType(oauth2.ResponseType(strings.TrimSpace(rt))) {
+			return errors.ErrInvalidRequest
+		}
+	}
+	return nil
+}
+
+// mergeAssertedClientMetadata overlays the claims a verified software
+// statement asserts onto client, so they can't be spoofed by the
+// surrounding, unverified registration request.
+func mergeAssertedClientMetadata(client, asserted *ClientRegistration) {
+	if asserted.ClientName != "" {
+		client.ClientName = asserted.ClientName
+	}
+	if len(asserted.RedirectURIs) > 0 {
+		client.RedirectURIs = asserted.RedirectURIs
+	}
+	if len(asserted.GrantTypes) > 0 {
+		client.GrantTypes = asserted.GrantTypes
+	}
+	if len(asserted.ResponseTypes) > 0 {
+		client.ResponseTypes = asserted.ResponseTypes
+	}
+	if asserted.TokenEndpointAuthMethod != "" {
+		client.TokenEndpointAuthMethod = asserted.TokenEndpointAuthMethod
+	}
+	if asserted.Scope != "" {
+		client.Scope = asserted.Scope
+	}
+	if asserted.JWKSURI != "" {
+		client.JWKSURI = asserted.JWKSURI
+	}
+	if len(asserted.JWKS) > 0 {
+		client.JWKS = asserted.JWKS
+	}
+	if len(asserted.Contacts) > 0 {
+		client.Contacts = asserted.Contacts
+	}
+	if asserted.PolicyURI != "" {
+		client.PolicyURI = asserted.PolicyURI
+	}
+}
+
+func (s *Server) writeClientRegistration(w http.ResponseWriter, client *ClientRegistration, statusCode int) error {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(client)
+}
+
+func randomToken(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
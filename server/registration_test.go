@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gopkg.in/oauth2.v3"
+)
+
+func newRegistrationServer(store *fakeClientRegistrationStore) *Server {
+	return &Server{
+		Config: &Config{
+			AllowedGrantTypes:    []oauth2.GrantType{oauth2.AuthorizationCode},
+			AllowedResponseTypes: []oauth2.ResponseType{oauth2.Code},
+		},
+		ClientRegistrationStore: store,
+	}
+}
+
+func registerRequest(t *testing.T, body interface{}) *http.Request {
+	t.Helper()
+	buf, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("marshal request body: %v", err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/register", bytes.NewReader(buf))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+func TestHandleClientRegistrationRequestRequiresInitialAccessToken(t *testing.T) {
+	srv := newRegistrationServer(&fakeClientRegistrationStore{clients: map[string]*ClientRegistration{}})
+	srv.InitialAccessTokenHandler = func(token string) (bool, error) {
+		return token == "valid-iat", nil
+	}
+
+	r := registerRequest(t, ClientRegistration{RedirectURIs: []string{"https://client.example.com/cb"}})
+	w := httptest.NewRecorder()
+	if err := srv.HandleClientRegistrationRequest(w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Code != http.StatusUnauthorized && w.Code != http.StatusBadRequest {
+		t.Errorf("expected a request with no initial access token to be rejected, got status %d", w.Code)
+	}
+
+	r = registerRequest(t, ClientRegistration{RedirectURIs: []string{"https://client.example.com/cb"}})
+	r.Header.Set("Authorization", "Bearer valid-iat")
+	w = httptest.NewRecorder()
+	if err := srv.HandleClientRegistrationRequest(w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected a request with a valid initial access token to succeed, got status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleClientRegistrationRequestSoftwareStatement(t *testing.T) {
+	srv := newRegistrationServer(&fakeClientRegistrationStore{clients: map[string]*ClientRegistration{}})
+	srv.SoftwareStatementHandler = func(statement string) (*ClientRegistration, error) {
+		if statement != "signed-statement" {
+			return nil, errInvalidStatement
+		}
+		return &ClientRegistration{ClientName: "Vendor Asserted Name"}, nil
+	}
+
+	r := registerRequest(t, ClientRegistration{
+		ClientName:        "Self-Reported Name",
+		RedirectURIs:      []string{"https://client.example.com/cb"},
+		SoftwareStatement: "signed-statement",
+	})
+	w := httptest.NewRecorder()
+	if err := srv.HandleClientRegistrationRequest(w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected registration to succeed, got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var got ClientRegistration
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response body is not valid JSON: %v", err)
+	}
+	if got.ClientName != "Vendor Asserted Name" {
+		t.Errorf("client_name = %q, want the software statement's asserted name", got.ClientName)
+	}
+	if got.SoftwareStatement != "" {
+		t.Error("expected the software_statement to be cleared before persisting/returning the registration")
+	}
+}
+
+func TestHandleClientRegistrationRequestRejectsUnverifiableSoftwareStatement(t *testing.T) {
+	srv := newRegistrationServer(&fakeClientRegistrationStore{clients: map[string]*ClientRegistration{}})
+
+	r := registerRequest(t, ClientRegistration{
+		RedirectURIs:      []string{"https://client.example.com/cb"},
+		SoftwareStatement: "signed-statement",
+	})
+	w := httptest.NewRecorder()
+	if err := srv.HandleClientRegistrationRequest(w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a software_statement with no SoftwareStatementHandler configured to be rejected, got status %d", w.Code)
+	}
+}
+
+func TestValidateClientMetadataRequiresRedirectURIsForAuthorizationCode(t *testing.T) {
+	srv := newRegistrationServer(&fakeClientRegistrationStore{clients: map[string]*ClientRegistration{}})
+
+	if err := srv.validateClientMetadata(&ClientRegistration{}); err == nil {
+		t.Error("expected a client with no redirect_uris to be rejected, since grant_types defaults to authorization_code")
+	}
+}
+
+func TestValidateClientMetadataAllowsNoRedirectURIsForClientCredentials(t *testing.T) {
+	srv := newRegistrationServer(&fakeClientRegistrationStore{clients: map[string]*ClientRegistration{}})
+	srv.Config.AllowedGrantTypes = append(srv.Config.AllowedGrantTypes, oauth2.ClientCredentials)
+
+	client := &ClientRegistration{GrantTypes: []string{string(oauth2.ClientCredentials)}}
+	if err := srv.validateClientMetadata(client); err != nil {
+		t.Errorf("expected a client_credentials-only client to not need redirect_uris, got: %v", err)
+	}
+}
+
+func TestValidateClientMetadataRejectsJWKSAndJWKSURITogether(t *testing.T) {
+	srv := newRegistrationServer(&fakeClientRegistrationStore{clients: map[string]*ClientRegistration{}})
+
+	client := &ClientRegistration{
+		RedirectURIs: []string{"https://client.example.com/cb"},
+		JWKSURI:      "https://client.example.com/jwks.json",
+		JWKS:         json.RawMessage(`{"keys":[]}`),
+	}
+	if err := srv.validateClientMetadata(client); err == nil {
+		t.Error("expected jwks and jwks_uri set together to be rejected")
+	}
+}
+
+// registrarManager is a Manager that also implements ClientRegistrar, to
+// exercise HandleClientRegistrationRequest's preference for it over
+// generating client_id/client_secret itself.
+type registrarManager struct {
+	introspectionManager
+	clientID, clientSecret string
+}
+
+func (m *registrarManager) RegisterClient(client *ClientRegistration) (string, string, error) {
+	return m.clientID, m.clientSecret, nil
+}
+
+func TestHandleClientRegistrationRequestUsesManagerClientRegistrar(t *testing.T) {
+	srv := newRegistrationServer(&fakeClientRegistrationStore{clients: map[string]*ClientRegistration{}})
+	srv.Manager = &registrarManager{clientID: "manager-assigned-id", clientSecret: "manager-assigned-secret"}
+
+	r := registerRequest(t, ClientRegistration{RedirectURIs: []string{"https://client.example.com/cb"}})
+	w := httptest.NewRecorder()
+	if err := srv.HandleClientRegistrationRequest(w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got ClientRegistration
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("response body is not valid JSON: %v", err)
+	}
+	if got.ClientID != "manager-assigned-id" || got.ClientSecret != "manager-assigned-secret" {
+		t.Errorf("client_id/client_secret = %q/%q, want the values from the Manager's ClientRegistrar", got.ClientID, got.ClientSecret)
+	}
+}
+
+var errInvalidStatement = &statementError{"invalid software statement"}
+
+type statementError struct{ msg string }
+
+func (e *statementError) Error() string { return e.msg }
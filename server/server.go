@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	perrors "github.com/pkg/errors"
@@ -26,7 +28,8 @@ func NewServer(cfg *Config, manager oauth2.Manager) *Server {
 		Manager: manager,
 	}
 
-	// default handler
+	// default handler; use ChainClientInfoHandler to additionally accept
+	// client_secret_post, client_secret_jwt or private_key_jwt
 	srv.ClientInfoHandler = ClientBasicHandler
 
 	srv.UserAuthorizationHandler = func(w http.ResponseWriter, r *http.Request) (string, error) {
@@ -49,12 +52,85 @@ type Server struct {
 	UserAuthorizationHandler     UserAuthorizationHandler
 	PasswordAuthorizationHandler PasswordAuthorizationHandler
 	RefreshingScopeHandler       RefreshingScopeHandler
-	ResponseErrorHandler         ResponseErrorHandler
-	InternalErrorHandler         InternalErrorHandler
-	ExtensionFieldsHandler       ExtensionFieldsHandler
-	AccessTokenExpHandler        AccessTokenExpHandler
-	AuthorizeScopeHandler        AuthorizeScopeHandler
-	CheckUserPermHandler         CheckUserPermHandler
+	// RefreshTokenRotationHandler, when true, has GetAccessToken invalidate
+	// the presented refresh token once the refreshing grant's Manager has
+	// issued a new one, so a stolen refresh token can be replayed at most
+	// once. This relies entirely on the Manager: it must implement
+	// tokenRevoker and must itself issue a fresh refresh token on every
+	// RefreshAccessToken call. GetAccessToken fails closed with
+	// errors.ErrServerError if either isn't true, rather than silently
+	// leaving the old refresh token live.
+	RefreshTokenRotationHandler bool
+
+	ResponseErrorHandler   ResponseErrorHandler
+	InternalErrorHandler   InternalErrorHandler
+	ExtensionFieldsHandler ExtensionFieldsHandler
+	AccessTokenExpHandler  AccessTokenExpHandler
+	AuthorizeScopeHandler  AuthorizeScopeHandler
+	CheckUserPermHandler   CheckUserPermHandler
+
+	// ClientAuthMethods, when non-empty, replaces ClientInfoHandler with a
+	// chain tried in order (via ChainClientInfoHandler) -- e.g.
+	// ClientBasicHandler, ClientSecretJWTHandler(...), ClientNoneHandler --
+	// so a server can accept several token_endpoint_auth_methods side by
+	// side instead of picking exactly one.
+	ClientAuthMethods []ClientInfoHandler
+
+	// Issuer identifies the authorization server in the `iss` claim of issued
+	// ID Tokens and in the OIDC discovery document. Required for OIDC.
+	Issuer string
+	// IDTokenGenerator issues the signed `id_token` returned alongside the
+	// access token whenever the granted scope contains "openid". Leave nil to
+	// disable OIDC.
+	IDTokenGenerator IDTokenGenerator
+	// UserInfoHandler supplies the claims HandleUserInfoRequest returns for
+	// ti's subject, beyond the mandatory `sub`. It's responsible for its own
+	// scope-gating (e.g. only returning `email` when ti.GetScope() contains
+	// "email"). Leave nil to return just `sub`.
+	UserInfoHandler UserInfoHandler
+
+	// ClientRegistrationStore backs HandleClientRegistrationRequest and its
+	// RFC 7592 management counterparts. Leave nil to disable dynamic client
+	// registration.
+	ClientRegistrationStore ClientRegistrationStore
+	// InitialAccessTokenHandler gates HandleClientRegistrationRequest on a
+	// bearer initial access token (RFC 7591 3). Leave nil for open
+	// registration.
+	InitialAccessTokenHandler InitialAccessTokenHandler
+	// SoftwareStatementHandler verifies a submitted software_statement and
+	// supplies the metadata it asserts. Leave nil to reject any registration
+	// request that includes one.
+	SoftwareStatementHandler SoftwareStatementHandler
+
+	// ForcePKCE requires a code_challenge on every authorization code
+	// request, regardless of client. See also RequirePKCEHandler for
+	// per-client enforcement.
+	ForcePKCE bool
+	// RequirePKCEHandler reports whether clientID must present a
+	// code_challenge on the authorization code grant. Ignored when
+	// ForcePKCE is true.
+	RequirePKCEHandler func(clientID string) (bool, error)
+	// CodeChallengeHandler looks up the code_challenge/code_challenge_method
+	// persisted for code, so GetAccessToken can verify the code_verifier on
+	// an authorization_code grant via VerifyCodeChallenge. Leave nil to skip
+	// verification (e.g. when the Manager already enforces it itself); when
+	// ForcePKCE or RequirePKCEHandler requires PKCE for the client, a nil
+	// CodeChallengeHandler makes GetAccessToken reject the grant with
+	// errors.ErrServerError instead.
+	CodeChallengeHandler func(code string) (challenge, method string, err error)
+
+	// DeviceUserCodeAlphabet and DeviceUserCodeLength configure the
+	// user_code charset/length passed to DeviceCodeManager.GenerateDeviceCode.
+	// Both default to the RFC 8628 Appendix A recommendation when left zero.
+	DeviceUserCodeAlphabet string
+	DeviceUserCodeLength   int
+	// DeviceCodePollInterval is the minimum time a client must wait between
+	// polls of the same device_code before GetAccessToken returns
+	// ErrSlowDown, independent of whatever the Manager itself enforces.
+	// Defaults to 5 seconds when zero.
+	DeviceCodePollInterval time.Duration
+	deviceLimiterInit      sync.Once
+	deviceLimiterInstance  *deviceCodeRateLimiter
 }
 
 func (s *Server) redirectError(w http.ResponseWriter, req *AuthorizeRequest, err error) error {
@@ -164,13 +240,59 @@ func (s *Server) ValidationAuthorizeRequest(r *http.Request) (*AuthorizeRequest,
 		return nil, errors.ErrUnauthorizedClient
 	}
 
+	codeChallenge := r.FormValue("code_challenge")
+	codeChallengeMethod := r.FormValue("code_challenge_method")
+	if !CheckCodeChallengeMethod(codeChallengeMethod) {
+		return nil, errors.ErrInvalidRequest
+	}
+	if codeChallenge != "" && !CheckCodeVerifier(codeChallenge) {
+		return nil, errors.ErrInvalidRequest
+	}
+
+	// nonce/max_age are OpenID Connect authentication request parameters
+	// (https://openid.net/specs/openid-connect-core-1_0.html#AuthRequest).
+	// They're only meaningful to the resource owner's authentication step, so
+	// they're recorded on AuthorizeRequest (and, for nonce, echoed back into
+	// the ID Token by GetTokenData) rather than enforced here.
+	// UserAuthorizationHandler is passed only the *http.Request, so it reads
+	// them from the request itself.
+	nonce := r.FormValue("nonce")
+	var maxAge int
+	if v := r.FormValue("max_age"); v != "" {
+		var err error
+		maxAge, err = strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.ErrInvalidRequest
+		}
+	}
+
+	if resType == oauth2.Code && codeChallenge == "" {
+		required := s.ForcePKCE
+		if !required {
+			if fn := s.RequirePKCEHandler; fn != nil {
+				var err error
+				required, err = fn(clientID)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if required {
+			return nil, errors.ErrInvalidRequest
+		}
+	}
+
 	req := &AuthorizeRequest{
-		RedirectURI:  redirectURI,
-		ResponseType: resType,
-		ClientID:     clientID,
-		State:        r.FormValue("state"),
-		Scope:        r.FormValue("scope"),
-		Request:      r,
+		RedirectURI:         redirectURI,
+		ResponseType:        resType,
+		ClientID:            clientID,
+		State:               r.FormValue("state"),
+		Scope:               r.FormValue("scope"),
+		CodeChallenge:       codeChallenge,
+		CodeChallengeMethod: codeChallengeMethod,
+		Nonce:               nonce,
+		MaxAge:              maxAge,
+		Request:             r,
 	}
 	return req, nil
 }
@@ -203,12 +325,16 @@ func (s *Server) GetAuthorizeToken(req *AuthorizeRequest) (oauth2.TokenInfo, err
 	}
 
 	tgr := &oauth2.TokenGenerateRequest{
-		ClientID:       req.ClientID,
-		UserID:         req.UserID,
-		RedirectURI:    req.RedirectURI,
-		Scope:          req.Scope,
-		AccessTokenExp: req.AccessTokenExp,
-		Request:        req.Request,
+		ClientID:            req.ClientID,
+		UserID:              req.UserID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		AccessTokenExp:      req.AccessTokenExp,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            req.AuthTime,
+		Request:             req.Request,
 	}
 	return s.Manager.GenerateAuthToken(req.ResponseType, tgr)
 }
@@ -243,6 +369,11 @@ func (s *Server) HandleAuthorizeRequest(w http.ResponseWriter, r *http.Request)
 		return nil
 	}
 	req.UserID = userID
+	// the moment UserAuthorizationHandler actually authenticated the end
+	// user, for OIDC's `auth_time` claim and `max_age` re-authentication
+	// checks -- not to be confused with the access/authorization code's own
+	// creation time.
+	req.AuthTime = time.Now()
 
 	// specify the scope of authorization
 	if fn := s.AuthorizeScopeHandler; fn != nil {
@@ -293,7 +424,7 @@ func (s *Server) ValidationTokenRequest(r *http.Request) (oauth2.GrantType, *oau
 		return "", nil, perrors.Wrap(errors.ErrUnsupportedGrantType, "no grant type")
 	}
 
-	clientID, clientSecret, err := s.ClientInfoHandler(r)
+	clientID, clientSecret, err := s.clientInfo(r)
 	if err != nil {
 		return "", nil, perrors.WithStack(err)
 	}
@@ -308,10 +439,14 @@ func (s *Server) ValidationTokenRequest(r *http.Request) (oauth2.GrantType, *oau
 	case oauth2.AuthorizationCode:
 		tgr.RedirectURI = r.FormValue("redirect_uri")
 		tgr.Code = r.FormValue("code")
+		tgr.CodeVerifier = r.FormValue("code_verifier")
 		if tgr.RedirectURI == "" ||
 			tgr.Code == "" {
 			return "", nil, perrors.Wrap(errors.ErrInvalidRequest, "missing redirect_uri or code")
 		}
+		if tgr.CodeVerifier != "" && !CheckCodeVerifier(tgr.CodeVerifier) {
+			return "", nil, perrors.Wrap(errors.ErrInvalidRequest, "malformed code_verifier")
+		}
 	case oauth2.PasswordCredentials:
 		tgr.Scope = r.FormValue("scope")
 		username, password := r.FormValue("username"), r.FormValue("password")
@@ -338,6 +473,11 @@ func (s *Server) ValidationTokenRequest(r *http.Request) (oauth2.GrantType, *oau
 		if tgr.Refresh == "" {
 			return "", nil, errors.ErrInvalidRequest
 		}
+	case DeviceCodeGrant:
+		tgr.Code = r.FormValue("device_code")
+		if tgr.Code == "" {
+			return "", nil, errors.ErrInvalidRequest
+		}
 	}
 	return gt, tgr, nil
 }
@@ -352,6 +492,23 @@ func (s *Server) CheckGrantType(gt oauth2.GrantType) bool {
 	return false
 }
 
+// DefaultRefreshingScopeHandler is the default RefreshingScopeHandler: it
+// reports whether every space-delimited scope in requested is present in
+// granted, i.e. exact set-inclusion with no further narrowing policy. Used
+// by GetAccessToken whenever Server.RefreshingScopeHandler is left nil.
+func DefaultRefreshingScopeHandler(requested, granted string) (bool, error) {
+	grantedSet := make(map[string]bool)
+	for _, sc := range strings.Fields(granted) {
+		grantedSet[sc] = true
+	}
+	for _, sc := range strings.Fields(requested) {
+		if !grantedSet[sc] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
 // GetAccessToken access token
 func (s *Server) GetAccessToken(gt oauth2.GrantType, tgr *oauth2.TokenGenerateRequest) (oauth2.TokenInfo, error) {
 	if allowed := s.CheckGrantType(gt); !allowed {
@@ -369,6 +526,34 @@ func (s *Server) GetAccessToken(gt oauth2.GrantType, tgr *oauth2.TokenGenerateRe
 
 	switch gt {
 	case oauth2.AuthorizationCode:
+		pkceRequired := s.ForcePKCE
+		if !pkceRequired {
+			if fn := s.RequirePKCEHandler; fn != nil {
+				var err error
+				pkceRequired, err = fn(tgr.ClientID)
+				if err != nil {
+					return nil, perrors.Wrap(err, "require PKCE handler")
+				}
+			}
+		}
+
+		if fn := s.CodeChallengeHandler; fn != nil {
+			challenge, method, err := fn(tgr.Code)
+			if err != nil {
+				return nil, perrors.WithStack(errors.ErrInvalidGrant)
+			}
+			if !VerifyCodeChallenge(tgr.CodeVerifier, challenge, method) {
+				return nil, perrors.Wrap(errors.ErrInvalidGrant, "code_verifier does not match code_challenge")
+			}
+		} else if pkceRequired {
+			// ForcePKCE/RequirePKCEHandler mandates a code_challenge, but
+			// without a CodeChallengeHandler there's nothing to verify the
+			// code_verifier against: failing open here would silently accept
+			// any authorization code regardless of PKCE, defeating the point
+			// of requiring it.
+			return nil, perrors.Wrap(errors.ErrServerError, "PKCE is required but no CodeChallengeHandler is configured to verify it")
+		}
+
 		ti, err := s.Manager.GenerateAccessToken(gt, tgr)
 		if err != nil {
 			switch err {
@@ -392,8 +577,9 @@ func (s *Server) GetAccessToken(gt oauth2.GrantType, tgr *oauth2.TokenGenerateRe
 		}
 		return s.Manager.GenerateAccessToken(gt, tgr)
 	case oauth2.Refreshing:
-		// check scope
-		if scope, scopeFn := tgr.Scope, s.RefreshingScopeHandler; scope != "" && scopeFn != nil {
+		// check scope narrowing: the requested scope (if any) must be a
+		// subset of the scope originally granted to the refresh token
+		if tgr.Scope != "" {
 			rti, err := s.Manager.LoadRefreshToken(tgr.Refresh)
 			if err != nil {
 				if err == errors.ErrInvalidRefreshToken || err == errors.ErrExpiredRefreshToken {
@@ -402,11 +588,37 @@ func (s *Server) GetAccessToken(gt oauth2.GrantType, tgr *oauth2.TokenGenerateRe
 				return nil, err
 			}
 
-			allowed, err := scopeFn(scope, rti.GetScope())
+			fn := s.RefreshingScopeHandler
+			if fn == nil {
+				fn = DefaultRefreshingScopeHandler
+			}
+			allowed, err := fn(tgr.Scope, rti.GetScope())
 			if err != nil {
 				return nil, err
-			} else if !allowed {
-				return nil, errors.ErrInvalidScope
+			}
+
+			var unauthorized []string
+			if !allowed {
+				granted := make(map[string]bool)
+				for _, sc := range strings.Fields(rti.GetScope()) {
+					granted[sc] = true
+				}
+				for _, sc := range strings.Fields(tgr.Scope) {
+					if !granted[sc] {
+						unauthorized = append(unauthorized, sc)
+					}
+				}
+				if len(unauthorized) == 0 {
+					// a custom RefreshingScopeHandler rejected the request
+					// for reasons beyond simple set-inclusion, so there's no
+					// specific culprit scope to single out
+					unauthorized = strings.Fields(tgr.Scope)
+				}
+			}
+
+			if len(unauthorized) > 0 {
+				return nil, perrors.Wrapf(errors.ErrInvalidScope,
+					"Requested scopes contain unauthorized scope(s): %q", unauthorized)
 			}
 		}
 
@@ -417,7 +629,40 @@ func (s *Server) GetAccessToken(gt oauth2.GrantType, tgr *oauth2.TokenGenerateRe
 			}
 			return nil, err
 		}
+
+		if s.RefreshTokenRotationHandler {
+			tr, ok := s.Manager.(tokenRevoker)
+			if !ok {
+				return nil, perrors.Wrap(errors.ErrServerError, "RefreshTokenRotationHandler is enabled but the Manager doesn't implement tokenRevoker")
+			}
+			newRefresh := ti.GetRefresh()
+			if newRefresh == "" || newRefresh == tgr.Refresh {
+				return nil, perrors.Wrap(errors.ErrServerError, "RefreshTokenRotationHandler is enabled but the Manager didn't issue a new refresh token")
+			}
+			if err := tr.RemoveRefreshToken(tgr.Refresh); err != nil {
+				return nil, err
+			}
+		}
 		return ti, nil
+	case DeviceCodeGrant:
+		dm, ok := s.Manager.(DeviceCodeManager)
+		if !ok {
+			return nil, perrors.WithStack(errors.ErrUnsupportedGrantType)
+		}
+
+		interval := s.DeviceCodePollInterval
+		if interval <= 0 {
+			interval = defaultDeviceCodePollInterval
+		}
+		if !s.deviceCodeLimiter().allow(tgr.Code, tgr.ClientID, interval) {
+			return nil, ErrSlowDown
+		}
+
+		ti, err := dm.PollDeviceCode(tgr.Code)
+		if err == ErrExpiredDeviceCode {
+			s.deviceCodeLimiter().evict(tgr.Code)
+		}
+		return ti, err
 	}
 
 	return nil, errors.ErrUnsupportedGrantType
@@ -439,6 +684,22 @@ func (s *Server) GetTokenData(ti oauth2.TokenInfo) map[string]interface{} {
 		data["refresh_token"] = refresh
 	}
 
+	if s.IDTokenGenerator != nil && scopeContains(ti.GetScope(), "openid") {
+		idToken, err := s.IDTokenGenerator.Token(&IDTokenData{
+			Issuer:    s.Issuer,
+			Subject:   ti.GetUserID(),
+			Audience:  ti.GetClientID(),
+			Nonce:     ti.GetNonce(),
+			AuthTime:  ti.GetAuthTime(),
+			ExpiresIn: ti.GetAccessExpiresIn(),
+		})
+		if err != nil {
+			logrus.Errorf("generate id_token: %+v", err)
+		} else {
+			data["id_token"] = idToken
+		}
+	}
+
 	if fn := s.ExtensionFieldsHandler; fn != nil {
 		ext := fn(ti)
 		for k, v := range ext {
@@ -459,13 +720,13 @@ func (s *Server) HandleTokenRequest(w http.ResponseWriter, r *http.Request) (str
 			return "", err
 		}
 		logrus.Infof("validate the token request: %+v", err)
-		return "", s.tokenError(w, perrors.Cause(err))
+		return "", s.tokenError(w, err)
 	}
 
 	ti, err := s.GetAccessToken(gt, tgr)
 	if err != nil {
 		logrus.Infof("get access token: %+v", err)
-		return "", s.tokenError(w, perrors.Cause(err))
+		return "", s.tokenError(w, err)
 	}
 
 	return ti.GetAccess(), s.token(w, s.GetTokenData(ti), nil)
@@ -474,10 +735,24 @@ func (s *Server) HandleTokenRequest(w http.ResponseWriter, r *http.Request) (str
 // GetErrorData get error response data
 func (s *Server) GetErrorData(err error) (map[string]interface{}, int, http.Header) {
 	var re errors.Response
-	if v, ok := errors.Descriptions[err]; ok {
-		re.Error = err
+
+	cause := perrors.Cause(err)
+	if v, ok := errors.Descriptions[cause]; ok {
+		re.Error = cause
 		re.Description = v
-		re.StatusCode = errors.StatusCodes[err]
+		if msg := err.Error(); msg != cause.Error() {
+			// a perrors.Wrap/Wrapf carries a more specific description than
+			// the cause's static text, e.g. which scopes were unauthorized.
+			// err.Error() is "<wrap message>: <cause.Error()>", so strip the
+			// appended cause rather than leaking the full chain verbatim.
+			re.Description = strings.TrimSuffix(msg, ": "+cause.Error())
+		}
+		re.StatusCode = errors.StatusCodes[cause]
+	} else if de, ok := cause.(*DeviceFlowError); ok {
+		// RFC 8628 3.5 polling statuses are returned as the `error` value
+		// directly, not mapped through errors.Descriptions.
+		re.Error = de
+		re.StatusCode = http.StatusBadRequest
 	} else {
 		if fn := s.InternalErrorHandler; fn != nil {
 			if v := fn(err); v != nil {
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/oauth2.v3"
+	"gopkg.in/oauth2.v3/errors"
+)
+
+func TestGetErrorDataStripsWrappedCause(t *testing.T) {
+	manager := &introspectionManager{byRefresh: map[string]*fakeTokenInfo{
+		"refresh-token": {scope: "read"},
+	}}
+	srv := &Server{
+		Config:  &Config{AllowedGrantTypes: []oauth2.GrantType{oauth2.Refreshing}},
+		Manager: manager,
+	}
+
+	_, err := srv.GetAccessToken(oauth2.Refreshing, &oauth2.TokenGenerateRequest{
+		Refresh: "refresh-token",
+		Scope:   "read admin",
+	})
+	if err == nil {
+		t.Fatal("expected an unauthorized-scope error")
+	}
+
+	data, _, _ := srv.GetErrorData(err)
+	desc, _ := data["error_description"].(string)
+	if desc != `Requested scopes contain unauthorized scope(s): ["admin"]` {
+		t.Errorf(`error_description = %q, want %q`, desc, `Requested scopes contain unauthorized scope(s): ["admin"]`)
+	}
+	if strings.Contains(desc, errors.ErrInvalidScope.Error()) {
+		t.Errorf("error_description leaked the wrapped cause's own text: %q", desc)
+	}
+}
+
+// rotatingManager is an introspectionManager whose RefreshAccessToken can be
+// scripted to return a token with a fresh (or unchanged) refresh token, to
+// exercise Server.RefreshTokenRotationHandler.
+type rotatingManager struct {
+	introspectionManager
+	newRefresh string
+}
+
+func (m *rotatingManager) RefreshAccessToken(tgr *oauth2.TokenGenerateRequest) (oauth2.TokenInfo, error) {
+	return &fakeTokenInfo{refresh: m.newRefresh}, nil
+}
+
+func TestGetAccessTokenRefreshTokenRotation(t *testing.T) {
+	manager := &rotatingManager{newRefresh: "new-refresh-token"}
+	srv := &Server{
+		Config:                      &Config{AllowedGrantTypes: []oauth2.GrantType{oauth2.Refreshing}},
+		Manager:                     manager,
+		RefreshTokenRotationHandler: true,
+	}
+
+	ti, err := srv.GetAccessToken(oauth2.Refreshing, &oauth2.TokenGenerateRequest{Refresh: "old-refresh-token"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ti.GetRefresh() != "new-refresh-token" {
+		t.Errorf("GetRefresh() = %q, want the new refresh token", ti.GetRefresh())
+	}
+	if len(manager.removedRefresh) != 1 || manager.removedRefresh[0] != "old-refresh-token" {
+		t.Errorf("removedRefresh = %v, want [old-refresh-token]", manager.removedRefresh)
+	}
+}
+
+func TestGetAccessTokenForcePKCEFailsClosedWithoutCodeChallengeHandler(t *testing.T) {
+	manager := &introspectionManager{}
+	srv := &Server{
+		Config: &Config{
+			AllowedGrantTypes: []oauth2.GrantType{oauth2.AuthorizationCode},
+		},
+		Manager:   manager,
+		ForcePKCE: true,
+	}
+
+	if _, err := srv.GetAccessToken(oauth2.AuthorizationCode, &oauth2.TokenGenerateRequest{Code: "auth-code"}); err == nil {
+		t.Fatal("expected an error when ForcePKCE is set but no CodeChallengeHandler can verify the code_verifier")
+	}
+}
+
+func TestGetAccessTokenRefreshTokenRotationFailsClosed(t *testing.T) {
+	manager := &rotatingManager{newRefresh: "old-refresh-token"}
+	srv := &Server{
+		Config:                      &Config{AllowedGrantTypes: []oauth2.GrantType{oauth2.Refreshing}},
+		Manager:                     manager,
+		RefreshTokenRotationHandler: true,
+	}
+
+	if _, err := srv.GetAccessToken(oauth2.Refreshing, &oauth2.TokenGenerateRequest{Refresh: "old-refresh-token"}); err == nil {
+		t.Fatal("expected an error when the Manager reused the refresh token instead of rotating it")
+	}
+}